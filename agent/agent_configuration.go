@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"regexp"
+	"syscall"
+)
+
+// AgentConfiguration contains the options used to configure how an agent
+// runs jobs. It's built directly from the flags passed to
+// `buildkite-agent start`.
+type AgentConfiguration struct {
+	BootstrapScript string
+	BuildPath       string
+	HooksPath       string
+	PluginsPath     string
+
+	GitCloneFlags string
+	GitCleanFlags string
+
+	SSHFingerprintVerification bool
+	CommandEval                bool
+	RunInPty                   bool
+
+	// AllowedEnvironmentVariables restricts which environment variables
+	// (other than the ones Buildkite itself sets) are passed through to
+	// the bootstrap script. A nil or empty slice allows everything.
+	AllowedEnvironmentVariables []*regexp.Regexp
+
+	DisconnectAfterJob         bool
+	DisconnectAfterJobTimeout  int
+	DisconnectAfterIdleTimeout int
+
+	// CancelSignal is forwarded to the bootstrap process group when the
+	// agent is asked to shut down.
+	CancelSignal              syscall.Signal
+	SignalsGracePeriodSeconds int
+}
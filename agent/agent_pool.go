@@ -0,0 +1,234 @@
+package agent
+
+import (
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/buildkite/agent/logger"
+)
+
+// AgentPool registers a single agent with Buildkite, then either runs one
+// acquired job (when AcquireJob is set) or polls for and runs pending jobs
+// until it's told to disconnect.
+type AgentPool struct {
+	Token    string
+	Name     string
+	Priority string
+
+	// AcquireJob, when set, tells the agent to claim this specific job
+	// UUID instead of polling the pending jobs queue, run it once, then
+	// disconnect.
+	AcquireJob string
+
+	MetaData                  []string
+	MetaDataFromScript        string
+	MetaDataFromScriptTimeout int
+	MetaDataEC2               bool
+	MetaDataEC2Tags           bool
+	MetaDataGCP               bool
+
+	Endpoint string
+
+	AgentConfiguration *AgentConfiguration
+
+	// ConfigFilePath is just used for logging out which config file was
+	// loaded, if any.
+	ConfigFilePath string
+
+	client *APIClient
+
+	shutdown shutdownState
+
+	mu        sync.Mutex
+	lastJobAt time.Time
+
+	// lastPollAtUnixNano is read/written atomically by markPoll and
+	// pingLoopHealthy, which the systemd watchdog uses to decide whether
+	// the poll loop is still alive.
+	lastPollAtUnixNano int64
+}
+
+// Start registers the agent with Buildkite and then runs jobs until the
+// agent is told to disconnect.
+func (p *AgentPool) Start() error {
+	p.client = NewAPIClient(p.Endpoint, p.Token)
+	p.shutdown.done = make(chan struct{})
+	p.lastJobAt = time.Now()
+
+	metaData, err := p.collectMetaData()
+	if err != nil {
+		return fmt.Errorf("failed to collect meta-data: %v", err)
+	}
+
+	if err := p.register(metaData); err != nil {
+		return fmt.Errorf("failed to register agent: %v", err)
+	}
+
+	if err := p.connect(metaData); err != nil {
+		return fmt.Errorf("failed to connect agent: %v", err)
+	}
+
+	// Only now that registration and the initial connect have both
+	// succeeded is it safe to tell systemd the agent is ready
+	p.notifyReady()
+
+	p.installSignalHandler()
+	go p.watchIdleTimeout()
+	go p.watchWatchdog()
+
+	if p.AcquireJob != "" {
+		return p.runAcquiredJob()
+	}
+
+	return p.pollForJobs()
+}
+
+// register logs the agent's name and the meta-data it registers with. It
+// sends no registration request to Buildkite.
+func (p *AgentPool) register(metaData []string) error {
+	logger.Info("Registering agent %q with meta-data %v", p.Name, metaData)
+
+	return nil
+}
+
+// connect logs the agent's name and the meta-data it connects with. It
+// opens no connection (websocket or otherwise) to Buildkite.
+func (p *AgentPool) connect(metaData []string) error {
+	logger.Info("Connecting agent %q with meta-data %v", p.Name, metaData)
+
+	return nil
+}
+
+// reconnect re-establishes the agent's connection to Buildkite; pollForJobs
+// calls it every reconnectInterval. Unlike the initial
+// connect in Start, it re-runs --meta-data-from-script first, so the
+// agent's tags stay current across reconnects.
+func (p *AgentPool) reconnect() error {
+	logger.Info("Reconnecting agent %q", p.Name)
+
+	metaData, err := p.collectMetaData()
+	if err != nil {
+		return err
+	}
+
+	return p.connect(metaData)
+}
+
+// runAcquiredJob claims the job specified by AcquireJob, runs it through the
+// bootstrap, then disconnects. This is the --acquire-job code path.
+func (p *AgentPool) runAcquiredJob() error {
+	logger.Info("Acquiring job %s", p.AcquireJob)
+
+	job, err := p.client.AcquireJob(p.AcquireJob)
+	if err != nil {
+		return fmt.Errorf("failed to acquire job %s: %v", p.AcquireJob, err)
+	}
+
+	if err := p.runJob(job); err != nil {
+		logger.Error("Job %s failed: %v", job.ID, err)
+	}
+
+	p.notifyStatus("disconnecting")
+	logger.Info("Disconnecting agent %q after acquired job %s", p.Name, job.ID)
+
+	return nil
+}
+
+// reconnectInterval is how often the agent refreshes its connection (and,
+// along with it, any --meta-data-from-script tags) while idle.
+const reconnectInterval = 5 * time.Minute
+
+// pollForJobs polls the pending jobs queue, running jobs as they're handed
+// to this agent, until the agent disconnects.
+func (p *AgentPool) pollForJobs() error {
+	lastReconnect := time.Now()
+
+	for {
+		select {
+		case <-p.shutdown.done:
+			logger.Info("Agent %q disconnecting", p.Name)
+			return nil
+		default:
+		}
+
+		if p.shutdown.isStopping() {
+			p.notifyStatus("disconnecting")
+			logger.Info("Agent %q disconnecting", p.Name)
+			return nil
+		}
+
+		p.markPoll()
+
+		if time.Since(lastReconnect) >= reconnectInterval {
+			if err := p.reconnect(); err != nil {
+				return err
+			}
+			lastReconnect = time.Now()
+		}
+
+		job, err := p.nextJob()
+		if err != nil {
+			return err
+		}
+
+		if job == nil {
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		if err := p.runJob(job); err != nil {
+			logger.Error("Job %s failed: %v", job.ID, err)
+		}
+	}
+}
+
+// nextJob returns the next pending job assigned to this agent. It makes no
+// request to Buildkite and always returns nil, so pollForJobs never runs a
+// job from the pending queue.
+func (p *AgentPool) nextJob() (*Job, error) {
+	return nil, nil
+}
+
+// runJob runs a single job through the bootstrap script, passing through
+// only the environment variables the agent is configured to allow. The
+// bootstrap runs in its own process group so that a cancel signal can be
+// forwarded to it (and anything it spawned) as a whole.
+func (p *AgentPool) runJob(job *Job) error {
+	p.mu.Lock()
+	p.lastJobAt = time.Now()
+	p.mu.Unlock()
+
+	p.notifyStatus(fmt.Sprintf("running job %s", job.ID))
+	defer p.notifyStatus("idle")
+
+	cmd := exec.Command(p.AgentConfiguration.BootstrapScript)
+	cmd.Env = p.bootstrapEnvironment()
+	setProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// exited is closed once cmd.Wait() returns, so that cancelRunningJob
+	// can stop waiting for the grace period as soon as the bootstrap
+	// exits, rather than always blocking for the full duration.
+	exited := make(chan struct{})
+	var waitErr error
+
+	go func() {
+		waitErr = cmd.Wait()
+		close(exited)
+	}()
+
+	p.shutdown.setRunning(cmd.Process.Pid, exited)
+	<-exited
+	p.shutdown.setRunning(0, nil)
+
+	p.mu.Lock()
+	p.lastJobAt = time.Now()
+	p.mu.Unlock()
+
+	return waitErr
+}
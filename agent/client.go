@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// APIClient is a minimal client for the parts of the Buildkite Agent API
+// that AgentPool needs to call directly, rather than through the bootstrap
+// script.
+type APIClient struct {
+	Endpoint string
+	Token    string
+
+	httpClient *http.Client
+}
+
+// NewAPIClient returns an APIClient authenticated with the given agent
+// registration token.
+func NewAPIClient(endpoint, token string) *APIClient {
+	return &APIClient{
+		Endpoint:   endpoint,
+		Token:      token,
+		httpClient: &http.Client{},
+	}
+}
+
+// Job is the subset of job fields the agent pool needs in order to run it.
+type Job struct {
+	ID    string `json:"id"`
+	State string `json:"state"`
+}
+
+// AcquireJob claims a specific job by UUID, instead of waiting for it to be
+// handed out by the pending jobs queue. It's used by --acquire-job so that
+// an agent process launched for exactly one job doesn't race other agents
+// for arbitrary work.
+func (c *APIClient) AcquireJob(uuid string) (*Job, error) {
+	if uuid == "" {
+		return nil, fmt.Errorf("no job uuid given to acquire")
+	}
+
+	url := fmt.Sprintf("%s/jobs/%s/acquire", c.Endpoint, uuid)
+
+	req, err := http.NewRequest("PUT", url, bytes.NewReader(nil))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Token "+c.Token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire job %s: %v", uuid, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to acquire job %s: unexpected status %s", uuid, resp.Status)
+	}
+
+	job := &Job{}
+	if err := json.NewDecoder(resp.Body).Decode(job); err != nil {
+		return nil, fmt.Errorf("failed to decode acquired job %s: %v", uuid, err)
+	}
+
+	return job, nil
+}
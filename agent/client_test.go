@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcquireJobRejectsEmptyUUID(t *testing.T) {
+	c := NewAPIClient("http://example.invalid", "token")
+
+	if _, err := c.AcquireJob(""); err == nil {
+		t.Fatal("expected an error for an empty job uuid")
+	}
+}
+
+func TestAcquireJobDecodesTheAcquiredJob(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			t.Errorf("expected PUT, got %s", r.Method)
+		}
+		if r.URL.Path != "/jobs/abc-123/acquire" {
+			t.Errorf("unexpected request path %q", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Token secret" {
+			t.Errorf("expected Authorization %q, got %q", "Token secret", got)
+		}
+
+		fmt.Fprint(w, `{"id": "abc-123", "state": "running"}`)
+	}))
+	defer server.Close()
+
+	c := NewAPIClient(server.URL, "secret")
+
+	job, err := c.AcquireJob("abc-123")
+	if err != nil {
+		t.Fatalf("AcquireJob() returned an error: %v", err)
+	}
+
+	if job.ID != "abc-123" || job.State != "running" {
+		t.Errorf("AcquireJob() = %+v, want ID abc-123, State running", job)
+	}
+}
+
+func TestAcquireJobReturnsErrorOnNonOKStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer server.Close()
+
+	c := NewAPIClient(server.URL, "secret")
+
+	if _, err := c.AcquireJob("abc-123"); err == nil {
+		t.Fatal("expected an error for a non-200 response")
+	}
+}
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/buildkite/agent/logger"
+)
+
+// buildkiteEnvironmentVariable reports whether an "KEY=value" environment
+// entry is one Buildkite itself sets (or a handful of other variables that
+// must always reach the bootstrap script, like CI and PATH), and so is
+// always allowed through regardless of --allowed-environment-variables.
+func buildkiteEnvironmentVariable(entry string) bool {
+	key := entry
+	if i := strings.IndexByte(entry, '='); i >= 0 {
+		key = entry[:i]
+	}
+
+	if strings.HasPrefix(key, "BUILDKITE") {
+		return true
+	}
+
+	switch key {
+	case "CI", "PATH", "HOME", "USER", "SHELL", "TERM":
+		return true
+	}
+
+	return false
+}
+
+// filterEnvironment returns the subset of env that's either set by
+// Buildkite itself or matches one of the allowed patterns. If allowed is
+// empty, env is returned unchanged. Filtered-out names are logged at debug
+// level so operators can see what was dropped.
+func filterEnvironment(env []string, allowed []*regexp.Regexp) []string {
+	if len(allowed) == 0 {
+		return env
+	}
+
+	filtered := make([]string, 0, len(env))
+
+	for _, entry := range env {
+		key := entry
+		if i := strings.IndexByte(entry, '='); i >= 0 {
+			key = entry[:i]
+		}
+
+		if buildkiteEnvironmentVariable(entry) {
+			filtered = append(filtered, entry)
+			continue
+		}
+
+		allowedByPattern := false
+		for _, re := range allowed {
+			if re.MatchString(key) {
+				allowedByPattern = true
+				break
+			}
+		}
+
+		if allowedByPattern {
+			filtered = append(filtered, entry)
+		} else {
+			logger.Debug("Not passing %q through to the bootstrap script (doesn't match --allowed-environment-variables)", key)
+		}
+	}
+
+	return filtered
+}
+
+// bootstrapEnvironment returns the environment that should be handed to the
+// bootstrap child process, after applying the agent's
+// AllowedEnvironmentVariables filter (if configured) to the parent's
+// environment.
+func (p *AgentPool) bootstrapEnvironment() []string {
+	return filterEnvironment(os.Environ(), p.AgentConfiguration.AllowedEnvironmentVariables)
+}
@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestBuildkiteEnvironmentVariable(t *testing.T) {
+	tests := []struct {
+		entry string
+		want  bool
+	}{
+		{"BUILDKITE_JOB_ID=abc", true},
+		{"BUILDKITE=true", true},
+		{"CI=true", true},
+		{"PATH=/usr/bin", true},
+		{"HOME=/root", true},
+		{"USER=root", true},
+		{"SHELL=/bin/bash", true},
+		{"TERM=xterm", true},
+		{"AWS_SECRET_ACCESS_KEY=secret", false},
+		{"SOME_OTHER_VAR=value", false},
+	}
+
+	for _, tt := range tests {
+		if got := buildkiteEnvironmentVariable(tt.entry); got != tt.want {
+			t.Errorf("buildkiteEnvironmentVariable(%q) = %v, want %v", tt.entry, got, tt.want)
+		}
+	}
+}
+
+func TestFilterEnvironmentWithNoAllowList(t *testing.T) {
+	env := []string{"BUILDKITE_JOB_ID=abc", "AWS_SECRET_ACCESS_KEY=secret"}
+
+	got := filterEnvironment(env, nil)
+	if !reflect.DeepEqual(got, env) {
+		t.Errorf("filterEnvironment() = %v, want env unchanged: %v", got, env)
+	}
+}
+
+func TestFilterEnvironmentAlwaysAllowsBuildkiteVariables(t *testing.T) {
+	env := []string{"BUILDKITE_JOB_ID=abc", "AWS_SECRET_ACCESS_KEY=secret"}
+	allowed := []*regexp.Regexp{regexp.MustCompile(`^NOTHING_MATCHES_THIS$`)}
+
+	got := filterEnvironment(env, allowed)
+	want := []string{"BUILDKITE_JOB_ID=abc"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("filterEnvironment() = %v, want %v", got, want)
+	}
+}
+
+func TestFilterEnvironmentMatchesAllowedPatterns(t *testing.T) {
+	env := []string{"BUILDKITE_JOB_ID=abc", "AWS_SECRET_ACCESS_KEY=secret", "AWS_REGION=us-east-1", "OTHER=nope"}
+	allowed := []*regexp.Regexp{regexp.MustCompile(`^AWS_REGION$`)}
+
+	got := filterEnvironment(env, allowed)
+	want := []string{"BUILDKITE_JOB_ID=abc", "AWS_REGION=us-east-1"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("filterEnvironment() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/buildkite/agent/logger"
+)
+
+// collectMetaData gathers the meta-data that will be attached to this
+// agent, merging the static --meta-data values with whatever
+// --meta-data-from-script produces. It's called once at Start (shared by
+// the initial register and connect) and again on every reconnect, so that
+// dynamically-computed tags (GPU model, kernel version, spot-vs-on-demand,
+// ...) stay current.
+func (p *AgentPool) collectMetaData() ([]string, error) {
+	metaData := append([]string{}, p.MetaData...)
+
+	if p.MetaDataFromScript != "" {
+		fromScript, err := metaDataFromScript(p.MetaDataFromScript, p.metaDataFromScriptTimeout())
+		if err != nil {
+			return nil, fmt.Errorf("--meta-data-from-script failed: %v", err)
+		}
+
+		metaData = append(metaData, fromScript...)
+	}
+
+	return metaData, nil
+}
+
+func (p *AgentPool) metaDataFromScriptTimeout() time.Duration {
+	if p.MetaDataFromScriptTimeout <= 0 {
+		return 30 * time.Second
+	}
+
+	return time.Duration(p.MetaDataFromScriptTimeout) * time.Second
+}
+
+// metaDataFromScript runs the given script and parses its stdout as
+// newline-separated "key=value" pairs. A non-zero exit status or a timeout
+// is treated as a fatal registration error by the caller.
+func metaDataFromScript(path string, timeout time.Duration) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path)
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("timed out after %s", timeout)
+		}
+		return nil, err
+	}
+
+	var metaData []string
+
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if !strings.Contains(line, "=") {
+			logger.Debug("Ignoring line from --meta-data-from-script that isn't key=value: %q", line)
+			continue
+		}
+
+		metaData = append(metaData, line)
+	}
+
+	return metaData, nil
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "meta-data-script")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	return path
+}
+
+func TestMetaDataFromScriptParsesKeyValueLines(t *testing.T) {
+	script := writeScript(t, "echo 'gpu=v100'\necho 'not a key value line'\necho 'spot=true'\n")
+
+	got, err := metaDataFromScript(script, time.Second)
+	if err != nil {
+		t.Fatalf("metaDataFromScript() returned an error: %v", err)
+	}
+
+	want := []string{"gpu=v100", "spot=true"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("metaDataFromScript() = %v, want %v", got, want)
+	}
+}
+
+func TestMetaDataFromScriptReturnsErrorOnNonZeroExit(t *testing.T) {
+	script := writeScript(t, "exit 1\n")
+
+	if _, err := metaDataFromScript(script, time.Second); err == nil {
+		t.Fatal("expected an error for a non-zero exit status")
+	}
+}
+
+func TestMetaDataFromScriptReturnsErrorOnTimeout(t *testing.T) {
+	// A builtin busy-loop rather than an external `sleep`: sh never forks,
+	// so killing it on timeout closes its stdout immediately instead of
+	// leaving an orphaned grandchild holding the pipe open.
+	script := writeScript(t, "while true; do :; done\n")
+
+	_, err := metaDataFromScript(script, 50*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected an error when the script exceeds its timeout")
+	}
+}
+
+func TestMetaDataFromScriptTimeout(t *testing.T) {
+	p := &AgentPool{}
+	if got, want := p.metaDataFromScriptTimeout(), 30*time.Second; got != want {
+		t.Errorf("default metaDataFromScriptTimeout() = %v, want %v", got, want)
+	}
+
+	p.MetaDataFromScriptTimeout = 5
+	if got, want := p.metaDataFromScriptTimeout(), 5*time.Second; got != want {
+		t.Errorf("metaDataFromScriptTimeout() = %v, want %v", got, want)
+	}
+}
+
+func TestCollectMetaDataMergesStaticAndScript(t *testing.T) {
+	script := writeScript(t, "echo 'gpu=v100'\n")
+
+	p := &AgentPool{
+		MetaData:           []string{"queue=default"},
+		MetaDataFromScript: script,
+	}
+
+	got, err := p.collectMetaData()
+	if err != nil {
+		t.Fatalf("collectMetaData() returned an error: %v", err)
+	}
+
+	want := []string{"queue=default", "gpu=v100"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("collectMetaData() = %v, want %v", got, want)
+	}
+}
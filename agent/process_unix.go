@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package agent
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup configures cmd to run in its own process group, so that a
+// cancel signal can be forwarded to it (and anything it spawned) as a
+// whole, rather than just the bootstrap process itself.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalProcessGroup forwards sig to the process group led by pid.
+func signalProcessGroup(pid int, sig syscall.Signal) {
+	_ = syscall.Kill(-pid, sig)
+}
+
+// killProcessGroup forwards SIGKILL to the process group led by pid.
+func killProcessGroup(pid int) {
+	_ = syscall.Kill(-pid, syscall.SIGKILL)
+}
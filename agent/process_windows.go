@@ -0,0 +1,28 @@
+//go:build windows
+// +build windows
+
+package agent
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup is a no-op on Windows: there's no equivalent of Unix's
+// setpgid/kill(-pgid) process-group signalling, so the bootstrap just runs
+// as a normal child process.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalProcessGroup is a no-op on Windows; there's nothing that can
+// receive CancelSignal gracefully, so cancellation relies on
+// killProcessGroup once the grace period elapses.
+func signalProcessGroup(pid int, sig syscall.Signal) {}
+
+// killProcessGroup terminates the process outright, since Windows has no
+// equivalent of a graceful process-group signal to forward first.
+func killProcessGroup(pid int) {
+	if proc, err := os.FindProcess(pid); err == nil {
+		_ = proc.Kill()
+	}
+}
@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/buildkite/agent/logger"
+	"github.com/buildkite/agent/process/sdnotify"
+)
+
+// pollHealthThreshold is how stale lastPollAt can get before the websocket
+// ping loop is considered unhealthy. It's a small multiple of the poll
+// loop's own sleep interval.
+const pollHealthThreshold = 15 * time.Second
+
+// notifyReady tells systemd the agent is ready. It's called once, after the
+// first successful register+connect, never before.
+func (p *AgentPool) notifyReady() {
+	if !sdnotify.Enabled() {
+		return
+	}
+
+	if err := sdnotify.Notify("READY=1"); err != nil {
+		logger.Error("Failed to notify systemd of readiness: %s", err)
+	}
+
+	p.notifyStatus("idle")
+}
+
+// notifyStatus sends a human-readable STATUS= line to systemd describing
+// what the agent is currently doing.
+func (p *AgentPool) notifyStatus(status string) {
+	if !sdnotify.Enabled() {
+		return
+	}
+
+	if err := sdnotify.Notify("STATUS=" + status); err != nil {
+		logger.Error("Failed to notify systemd of status: %s", err)
+	}
+}
+
+// notifyReloading wraps a config reload with RELOADING=1/READY=1, as
+// sd_notify(3) expects.
+func (p *AgentPool) notifyReloading(reload func() error) error {
+	if !sdnotify.Enabled() {
+		return reload()
+	}
+
+	if err := sdnotify.Notify("RELOADING=1"); err != nil {
+		logger.Error("Failed to notify systemd of reload: %s", err)
+	}
+
+	err := reload()
+
+	if notifyErr := sdnotify.Notify("READY=1"); notifyErr != nil {
+		logger.Error("Failed to notify systemd of readiness: %s", notifyErr)
+	}
+
+	return err
+}
+
+// Reload sends the systemd RELOADING=1/READY=1 notifications around a reload
+// step, so that `systemctl reload` observers see the agent leave and
+// re-enter the ready state. The reload step only logs; no configuration is
+// re-read.
+func (p *AgentPool) Reload() error {
+	return p.notifyReloading(func() error {
+		logger.Info("Reloading agent %q", p.Name)
+		return nil
+	})
+}
+
+// markPoll records that the poll loop completed an iteration, which is what
+// the watchdog uses to decide whether the agent is still healthy.
+func (p *AgentPool) markPoll() {
+	atomic.StoreInt64(&p.lastPollAtUnixNano, time.Now().UnixNano())
+}
+
+// pingLoopHealthy reports whether the poll loop has run recently enough
+// that the agent should be considered alive. While a job is running the
+// poll loop is blocked in runJob, so the agent counts as alive then too.
+func (p *AgentPool) pingLoopHealthy() bool {
+	if p.shutdown.jobRunning() {
+		return true
+	}
+
+	last := atomic.LoadInt64(&p.lastPollAtUnixNano)
+	if last == 0 {
+		return true
+	}
+
+	return time.Since(time.Unix(0, last)) < pollHealthThreshold
+}
+
+// watchWatchdog pings WATCHDOG=1 at half of WATCHDOG_USEC, but only while
+// the poll loop is healthy - a hung agent stops pinging and systemd
+// restarts it.
+func (p *AgentPool) watchWatchdog() {
+	if !sdnotify.Enabled() {
+		return
+	}
+
+	interval, ok := sdnotify.WatchdogInterval()
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.shutdown.done:
+			return
+		case <-ticker.C:
+			if !p.pingLoopHealthy() {
+				logger.Error("Not sending systemd watchdog ping: poll loop looks unhealthy")
+				continue
+			}
+
+			if err := sdnotify.Notify("WATCHDOG=1"); err != nil {
+				logger.Error("Failed to notify systemd watchdog: %s", err)
+			}
+		}
+	}
+}
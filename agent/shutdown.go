@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/buildkite/agent/logger"
+)
+
+// shutdownState tracks the agent's draining/stopping status, plus whatever
+// bootstrap process group is currently running, so a cancel signal can be
+// forwarded to it.
+type shutdownState struct {
+	mu sync.Mutex
+
+	stopping bool
+
+	// runningPgid and runningDone describe the bootstrap process group
+	// currently running, if any. runningDone is closed when it exits, so
+	// cancelRunningJob can stop waiting as soon as that happens instead of
+	// always blocking for the full grace period.
+	runningPgid int
+	runningDone chan struct{}
+
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// markDone closes the done channel exactly once, however shutdown was
+// triggered (signal or idle timeout).
+func (s *shutdownState) markDone() {
+	s.doneOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// installSignalHandler listens for SIGTERM/SIGINT - the signals process
+// supervisors (systemd, Kubernetes, ...) use to ask a process to shut down -
+// and begins a graceful shutdown when either arrives: stop accepting new
+// jobs, forward CancelSignal to the running bootstrap process group, wait
+// up to SignalsGracePeriodSeconds, then SIGKILL it.
+//
+// This is deliberately unconditional: CancelSignal only controls what's
+// forwarded to the bootstrap, not what triggers shutdown. If it were used
+// here too, --cancel-signal SIGHUP would stop the agent reacting to
+// SIGTERM/SIGINT at all, and --cancel-signal SIGKILL (also a documented
+// value) could never be caught by signal.Notify in the first place.
+func (p *AgentPool) installSignalHandler() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		s := <-sig
+		logger.Info("Received %s, disconnecting agent %q", s, p.Name)
+		p.shutdown.beginStopping()
+		p.cancelRunningJob()
+		p.shutdown.markDone()
+	}()
+}
+
+// beginStopping marks the pool as draining, so the poll loop stops picking
+// up new jobs.
+func (s *shutdownState) beginStopping() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopping = true
+}
+
+// isStopping reports whether the pool has been told to stop accepting new
+// jobs.
+func (s *shutdownState) isStopping() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopping
+}
+
+// setRunning records the process group of the bootstrap currently running,
+// and the channel that's closed when it exits, so a cancel signal has
+// something to forward to and cancelRunningJob knows when to stop waiting.
+func (s *shutdownState) setRunning(pgid int, done chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runningPgid = pgid
+	s.runningDone = done
+}
+
+// jobRunning reports whether a bootstrap process group is currently running.
+func (s *shutdownState) jobRunning() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.runningPgid != 0
+}
+
+// cancelRunningJob forwards the configured CancelSignal to the running
+// bootstrap's process group (if any), then waits up to
+// SignalsGracePeriodSeconds for it to exit - returning as soon as it does,
+// rather than always blocking for the full grace period - before sending
+// SIGKILL.
+func (p *AgentPool) cancelRunningJob() {
+	p.shutdown.mu.Lock()
+	pgid := p.shutdown.runningPgid
+	done := p.shutdown.runningDone
+	p.shutdown.mu.Unlock()
+
+	if pgid == 0 {
+		return
+	}
+
+	logger.Info("Forwarding %s to bootstrap process group %d", p.AgentConfiguration.CancelSignal, pgid)
+	signalProcessGroup(pgid, p.AgentConfiguration.CancelSignal)
+
+	grace := time.Duration(p.AgentConfiguration.SignalsGracePeriodSeconds) * time.Second
+
+	select {
+	case <-done:
+		return
+	case <-time.After(grace):
+	}
+
+	logger.Info("Bootstrap process group %d didn't exit within %s, sending SIGKILL", pgid, grace)
+	killProcessGroup(pgid)
+}
+
+// watchIdleTimeout disconnects the agent if it goes longer than
+// DisconnectAfterIdleTimeout without running a job.
+func (p *AgentPool) watchIdleTimeout() {
+	if p.AgentConfiguration.DisconnectAfterIdleTimeout <= 0 {
+		return
+	}
+
+	idleTimeout := time.Duration(p.AgentConfiguration.DisconnectAfterIdleTimeout) * time.Second
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.shutdown.done:
+			return
+		case <-ticker.C:
+			if p.shutdown.jobRunning() {
+				continue
+			}
+
+			p.mu.Lock()
+			idleFor := time.Since(p.lastJobAt)
+			p.mu.Unlock()
+
+			if idleFor >= idleTimeout {
+				logger.Info("Agent %q has been idle for %s, disconnecting", p.Name, idleFor)
+				p.shutdown.beginStopping()
+				p.shutdown.markDone()
+				return
+			}
+		}
+	}
+}
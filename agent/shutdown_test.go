@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShutdownStateBeginStoppingIsStopping(t *testing.T) {
+	s := &shutdownState{}
+
+	if s.isStopping() {
+		t.Fatal("expected isStopping() to be false before beginStopping()")
+	}
+
+	s.beginStopping()
+
+	if !s.isStopping() {
+		t.Fatal("expected isStopping() to be true after beginStopping()")
+	}
+}
+
+func TestShutdownStateMarkDoneIsIdempotent(t *testing.T) {
+	s := &shutdownState{done: make(chan struct{})}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.markDone()
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case <-s.done:
+	default:
+		t.Fatal("expected done channel to be closed")
+	}
+}
+
+// TestInstallSignalHandlerReactsRegardlessOfCancelSignal guards against the
+// signal handler only listening for whatever --cancel-signal was
+// configured to: a pool forwarding SIGHUP to its bootstrap must still shut
+// down on SIGINT, and a pool configured with SIGKILL (which os/signal can
+// never catch) must still shut down at all.
+func TestInstallSignalHandlerReactsRegardlessOfCancelSignal(t *testing.T) {
+	p := &AgentPool{
+		Name: "test",
+		AgentConfiguration: &AgentConfiguration{
+			CancelSignal:              syscall.SIGHUP,
+			SignalsGracePeriodSeconds: 0,
+		},
+	}
+	p.shutdown.done = make(chan struct{})
+
+	p.installSignalHandler()
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatalf("failed to send SIGINT to self: %v", err)
+	}
+
+	select {
+	case <-p.shutdown.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected SIGINT to trigger shutdown even though CancelSignal is SIGHUP")
+	}
+}
+
+func TestCancelRunningJobReturnsAsSoonAsTheProcessExits(t *testing.T) {
+	p := &AgentPool{
+		AgentConfiguration: &AgentConfiguration{
+			CancelSignal:              syscall.SIGTERM,
+			SignalsGracePeriodSeconds: 30,
+		},
+	}
+
+	exited := make(chan struct{})
+	close(exited)
+	p.shutdown.setRunning(12345, exited)
+
+	start := time.Now()
+	p.cancelRunningJob()
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("cancelRunningJob() took %s, expected it to return as soon as the process exited, not wait out the full grace period", elapsed)
+	}
+}
@@ -1,9 +1,14 @@
 package clicommand
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
 
 	"github.com/buildkite/agent/agent"
 	"github.com/buildkite/agent/cliconfig"
@@ -11,6 +16,15 @@ import (
 	"github.com/codegangsta/cli"
 )
 
+// cancelSignals maps the signal names accepted by --cancel-signal to their
+// syscall.Signal values
+var cancelSignals = map[string]syscall.Signal{
+	"SIGTERM": syscall.SIGTERM,
+	"SIGINT":  syscall.SIGINT,
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGKILL": syscall.SIGKILL,
+}
+
 var StartDescription = `Usage:
 
    buildkite-agent start [arguments...]
@@ -33,14 +47,22 @@ type AgentStartConfig struct {
 	Token                        string   `cli:"token" validate:"required"`
 	Name                         string   `cli:"name"`
 	Priority                     string   `cli:"priority"`
+	AcquireJob                   string   `cli:"acquire-job"`
 	DisconnectAfterJob           bool     `cli:"disconnect-after-job"`
 	DisconnectAfterJobTimeout    int      `cli:"disconnect-after-job-timeout"`
+	DisconnectAfterIdleTimeout   int      `cli:"disconnect-after-idle-timeout"`
+	CancelSignal                 string   `cli:"cancel-signal"`
+	SignalsGracePeriodSeconds    int      `cli:"signals-grace-period-seconds"`
+	Spawn                        int      `cli:"spawn"`
 	BootstrapScript              string   `cli:"bootstrap-script" normalize:"filepath" validate:"required"`
 	BuildPath                    string   `cli:"build-path" normalize:"filepath" validate:"required"`
 	HooksPath                    string   `cli:"hooks-path" normalize:"filepath"`
 	PluginsPath                  string   `cli:"plugins-path" normalize:"filepath"`
 	MetaData                     []string `cli:"meta-data"`
+	MetaDataFromScript           string   `cli:"meta-data-from-script" normalize:"filepath"`
+	MetaDataFromScriptTimeout    int      `cli:"meta-data-from-script-timeout"`
 	Experiments                  []string `cli:"experiment"`
+	AllowedEnvironmentVariables  []string `cli:"allowed-environment-variables"`
 	MetaDataEC2                  bool     `cli:"meta-data-ec2"`
 	MetaDataEC2Tags              bool     `cli:"meta-data-ec2-tags"`
 	MetaDataGCP                  bool     `cli:"meta-data-gcp"`
@@ -109,6 +131,12 @@ var AgentStartCommand = cli.Command{
 			Usage:  "The priority of the agent (higher priorities are assigned work first)",
 			EnvVar: "BUILDKITE_AGENT_PRIORITY",
 		},
+		cli.StringFlag{
+			Name:   "acquire-job",
+			Value:  "",
+			Usage:  "Start this agent and only run the specified job, disconnecting after it has finished",
+			EnvVar: "BUILDKITE_AGENT_ACQUIRE_JOB",
+		},
 		cli.BoolFlag{
 			Name:   "disconnect-after-job",
 			Usage:  "Disconnect the agent after running a job",
@@ -120,12 +148,48 @@ var AgentStartCommand = cli.Command{
 			Usage:  "When --disconnect-after-job is specified, the number of seconds to wait for a job before shutting down",
 			EnvVar: "BUILDKITE_AGENT_DISCONNECT_AFTER_JOB_TIMEOUT",
 		},
+		cli.IntFlag{
+			Name:   "disconnect-after-idle-timeout",
+			Value:  0,
+			Usage:  "The number of seconds the agent can be idle for before shutting down (0 means never)",
+			EnvVar: "BUILDKITE_AGENT_DISCONNECT_AFTER_IDLE_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "cancel-signal",
+			Value:  "SIGTERM",
+			Usage:  "The signal to use for cancellation, forwarded to the running bootstrap process group (SIGTERM, SIGINT, SIGHUP, or SIGKILL)",
+			EnvVar: "BUILDKITE_CANCEL_SIGNAL",
+		},
+		cli.IntFlag{
+			Name:   "signals-grace-period-seconds",
+			Value:  10,
+			Usage:  "The number of seconds to wait for the bootstrap process group to exit after --cancel-signal before sending SIGKILL",
+			EnvVar: "BUILDKITE_SIGNALS_GRACE_PERIOD_SECONDS",
+		},
+		cli.IntFlag{
+			Name:   "spawn",
+			Value:  1,
+			Usage:  "The number of agents to spawn in this process, each registering as a separate agent",
+			EnvVar: "BUILDKITE_AGENT_SPAWN",
+		},
 		cli.StringSliceFlag{
 			Name:   "meta-data",
 			Value:  &cli.StringSlice{},
 			Usage:  "Meta-data for the agent (default is \"queue=default\")",
 			EnvVar: "BUILDKITE_AGENT_META_DATA",
 		},
+		cli.StringFlag{
+			Name:   "meta-data-from-script",
+			Value:  "",
+			Usage:  "Path to a script that outputs newline-separated key=value meta-data pairs, run at registration and on every reconnect",
+			EnvVar: "BUILDKITE_AGENT_META_DATA_FROM_SCRIPT",
+		},
+		cli.IntFlag{
+			Name:   "meta-data-from-script-timeout",
+			Value:  30,
+			Usage:  "The number of seconds to wait for the --meta-data-from-script to finish before failing registration",
+			EnvVar: "BUILDKITE_AGENT_META_DATA_FROM_SCRIPT_TIMEOUT",
+		},
 		cli.BoolFlag{
 			Name:  "meta-data-ec2",
 			Usage: "Include the host's EC2 meta-data (instance-id, instance-type, and ami-id) as meta-data",
@@ -141,6 +205,12 @@ var AgentStartCommand = cli.Command{
 			Usage: "Include the host's Google Cloud meta-data (instance-id, machine-type, preemptible, project-id, region, and zone) as meta-data",
 			EnvVar: "BUILDKITE_AGENT_META_DATA_GCP",
 		},
+		cli.StringSliceFlag{
+			Name:   "allowed-environment-variables",
+			Value:  &cli.StringSlice{},
+			Usage:  "A comma-separated list of regular expressions matching environment variables that are allowed to be passed through to the bootstrap script, in addition to variables set by Buildkite itself",
+			EnvVar: "BUILDKITE_ALLOWED_ENVIRONMENT_VARIABLES",
+		},
 		cli.StringFlag{
 			Name:   "git-clone-flags",
 			Value:  "-v",
@@ -224,45 +294,130 @@ var AgentStartCommand = cli.Command{
 			cfg.NoPTY = true
 		}
 
-		// Make sure the DisconnectAfterJobTimeout value is correct
+		// --acquire-job always implies --disconnect-after-job, since the
+		// agent only exists to run the one job it was given
+		if cfg.AcquireJob != "" {
+			cfg.DisconnectAfterJob = true
+		}
+
+		// Make sure the DisconnectAfterJobTimeout value is correct. This
+		// must run after the --acquire-job coercion above, otherwise an
+		// explicit --acquire-job with a too-low --disconnect-after-job-timeout
+		// (but no explicit --disconnect-after-job) would skip this check.
 		if cfg.DisconnectAfterJob && cfg.DisconnectAfterJobTimeout < 120 {
 			logger.Fatal("The timeout for `disconnect-after-job` must be at least 120 seconds")
 		}
 
-		// Setup the agent
-		pool := agent.AgentPool{
-			Token:           cfg.Token,
-			Name:            cfg.Name,
-			Priority:        cfg.Priority,
-			MetaData:        cfg.MetaData,
-			MetaDataEC2:     cfg.MetaDataEC2,
-			MetaDataEC2Tags: cfg.MetaDataEC2Tags,
-			MetaDataGCP:     cfg.MetaDataGCP,
-			Endpoint:        cfg.Endpoint,
-			AgentConfiguration: &agent.AgentConfiguration{
-				BootstrapScript:            cfg.BootstrapScript,
-				BuildPath:                  cfg.BuildPath,
-				HooksPath:                  cfg.HooksPath,
-				PluginsPath:                cfg.PluginsPath,
-				GitCloneFlags:              cfg.GitCloneFlags,
-				GitCleanFlags:              cfg.GitCleanFlags,
-				SSHFingerprintVerification: !cfg.NoSSHFingerprintVerification,
-				CommandEval:                !cfg.NoCommandEval,
-				RunInPty:                   !cfg.NoPTY,
-				DisconnectAfterJob:         cfg.DisconnectAfterJob,
-				DisconnectAfterJobTimeout:  cfg.DisconnectAfterJobTimeout,
-			},
+		// --acquire-job claims one specific job by UUID; spawning more than
+		// one agent for it would just have them race each other to claim
+		// the same job, so it isn't allowed
+		if cfg.AcquireJob != "" && cfg.Spawn > 1 {
+			logger.Fatal("The `spawn` option cannot be used with `acquire-job`")
 		}
 
-		// Store the loaded config file path on the pool so we can
-		// show it when the agent starts
-		if loader.File != nil {
-			pool.ConfigFilePath = loader.File.Path
+		// Make sure the cancel signal is one we know how to forward
+		cancelSignal, ok := cancelSignals[cfg.CancelSignal]
+		if !ok {
+			logger.Fatal("Unknown cancel signal %q (must be one of SIGTERM, SIGINT, SIGHUP, SIGKILL)", cfg.CancelSignal)
 		}
 
-		// Start the agent pool
-		if err := pool.Start(); err != nil {
-			logger.Fatal("%s", err)
+		// Compile the allowed environment variable patterns once up-front,
+		// rather than on every job
+		allowedEnvironmentVariables := make([]*regexp.Regexp, len(cfg.AllowedEnvironmentVariables))
+		for i, pattern := range cfg.AllowedEnvironmentVariables {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				logger.Fatal("Invalid --allowed-environment-variables pattern %q: %s", pattern, err)
+			}
+			allowedEnvironmentVariables[i] = re
+		}
+
+		if cfg.Spawn < 1 {
+			logger.Fatal("The value of `spawn` must be at least 1")
+		}
+
+		// newPool builds an independent agent pool for one of the spawned
+		// agents. Each pool gets its own name, but otherwise shares the
+		// loaded configuration.
+		newPool := func(name string) *agent.AgentPool {
+			pool := &agent.AgentPool{
+				Token:                     cfg.Token,
+				Name:                      name,
+				Priority:                  cfg.Priority,
+				AcquireJob:                cfg.AcquireJob,
+				MetaData:                  cfg.MetaData,
+				MetaDataFromScript:        cfg.MetaDataFromScript,
+				MetaDataFromScriptTimeout: cfg.MetaDataFromScriptTimeout,
+				MetaDataEC2:               cfg.MetaDataEC2,
+				MetaDataEC2Tags:           cfg.MetaDataEC2Tags,
+				MetaDataGCP:               cfg.MetaDataGCP,
+				Endpoint:                  cfg.Endpoint,
+				AgentConfiguration: &agent.AgentConfiguration{
+					BootstrapScript:             cfg.BootstrapScript,
+					BuildPath:                   cfg.BuildPath,
+					HooksPath:                   cfg.HooksPath,
+					PluginsPath:                 cfg.PluginsPath,
+					GitCloneFlags:               cfg.GitCloneFlags,
+					GitCleanFlags:               cfg.GitCleanFlags,
+					AllowedEnvironmentVariables: allowedEnvironmentVariables,
+					SSHFingerprintVerification:  !cfg.NoSSHFingerprintVerification,
+					CommandEval:                 !cfg.NoCommandEval,
+					RunInPty:                    !cfg.NoPTY,
+					DisconnectAfterJob:          cfg.DisconnectAfterJob,
+					DisconnectAfterJobTimeout:   cfg.DisconnectAfterJobTimeout,
+					DisconnectAfterIdleTimeout:  cfg.DisconnectAfterIdleTimeout,
+					CancelSignal:                cancelSignal,
+					SignalsGracePeriodSeconds:   cfg.SignalsGracePeriodSeconds,
+				},
+			}
+
+			// Store the loaded config file path on the pool so we can
+			// show it when the agent starts
+			if loader.File != nil {
+				pool.ConfigFilePath = loader.File.Path
+			}
+
+			return pool
+		}
+
+		if cfg.Spawn == 1 {
+			pool := newPool(cfg.Name)
+
+			// Start the agent pool
+			if err := pool.Start(); err != nil {
+				logger.Fatal("%s", err)
+			}
+
+			return
+		}
+
+		// When spawning more than one agent, run each pool concurrently in
+		// its own goroutine, named so they're distinguishable from each
+		// other, and wait for all of them to finish before exiting
+		var wg sync.WaitGroup
+		var failed int32
+
+		for i := 1; i <= cfg.Spawn; i++ {
+			pool := newPool(fmt.Sprintf("%s-%d", cfg.Name, i))
+
+			wg.Add(1)
+			go func(pool *agent.AgentPool) {
+				defer wg.Done()
+
+				if err := pool.Start(); err != nil {
+					logger.Error("%s", err)
+					atomic.AddInt32(&failed, 1)
+				}
+			}(pool)
+		}
+
+		wg.Wait()
+
+		// Match the exit behaviour of the cfg.Spawn == 1 path above: a
+		// failed pool must not look like a successful run to orchestrators
+		// watching the exit code
+		if failed > 0 {
+			logger.Fatal("%d of %d spawned agents failed to start", failed, cfg.Spawn)
 		}
 	},
 }
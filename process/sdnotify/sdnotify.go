@@ -0,0 +1,57 @@
+// Package sdnotify implements the systemd readiness/watchdog notification
+// protocol (see sd_notify(3)) without depending on cgo or libsystemd. It's a
+// thin, no-op-when-absent client: if the agent isn't running under systemd,
+// every call here is a harmless no-op.
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Enabled reports whether the process is running under systemd with
+// notification support, i.e. whether NOTIFY_SOCKET is set.
+func Enabled() bool {
+	return os.Getenv("NOTIFY_SOCKET") != ""
+}
+
+// Notify sends a state string (e.g. "READY=1", "STATUS=...") to the
+// systemd notification socket. It's a no-op if NOTIFY_SOCKET isn't set.
+func Notify(state string) error {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return nil
+	}
+
+	addr := &net.UnixAddr{Name: socketPath, Net: "unixgram"}
+
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+
+	return err
+}
+
+// WatchdogInterval returns the interval at which WATCHDOG=1 pings should be
+// sent, based on the WATCHDOG_USEC environment variable. The returned
+// duration is half of WATCHDOG_USEC, as recommended by sd_notify(3). ok is
+// false if no watchdog has been requested.
+func WatchdogInterval() (interval time.Duration, ok bool) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+
+	return (time.Duration(n) * time.Microsecond) / 2, true
+}
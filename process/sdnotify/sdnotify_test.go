@@ -0,0 +1,98 @@
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnabled(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	if Enabled() {
+		t.Error("expected Enabled() to be false when NOTIFY_SOCKET is unset")
+	}
+
+	os.Setenv("NOTIFY_SOCKET", "/tmp/notify.sock")
+	defer os.Setenv("NOTIFY_SOCKET", "")
+
+	if !Enabled() {
+		t.Error("expected Enabled() to be true when NOTIFY_SOCKET is set")
+	}
+}
+
+func TestNotifyIsNoopWithoutNotifySocket(t *testing.T) {
+	os.Setenv("NOTIFY_SOCKET", "")
+
+	if err := Notify("READY=1"); err != nil {
+		t.Errorf("expected Notify() to be a no-op without NOTIFY_SOCKET, got: %v", err)
+	}
+}
+
+func TestNotifySendsToSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "notify.sock")
+
+	addr := &net.UnixAddr{Name: socketPath, Net: "unixgram"}
+	conn, err := net.ListenUnixgram("unixgram", addr)
+	if err != nil {
+		t.Fatalf("failed to listen on test socket: %v", err)
+	}
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", socketPath)
+	defer os.Setenv("NOTIFY_SOCKET", "")
+
+	if err := Notify("READY=1"); err != nil {
+		t.Fatalf("Notify() returned an error: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read notification: %v", err)
+	}
+
+	if got := string(buf[:n]); got != "READY=1" {
+		t.Errorf("expected to receive %q, got %q", "READY=1", got)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		name       string
+		usec       string
+		unset      bool
+		wantOK     bool
+		wantResult time.Duration
+	}{
+		{name: "unset", unset: true, wantOK: false},
+		{name: "empty is treated as unset", usec: "", wantOK: false},
+		{name: "zero", usec: "0", wantOK: false},
+		{name: "negative", usec: "-1000000", wantOK: false},
+		{name: "non-numeric", usec: "not-a-number", wantOK: false},
+		{name: "valid", usec: "20000000", wantOK: true, wantResult: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.unset {
+				os.Unsetenv("WATCHDOG_USEC")
+			} else {
+				os.Setenv("WATCHDOG_USEC", tt.usec)
+			}
+			defer os.Setenv("WATCHDOG_USEC", "")
+
+			interval, ok := WatchdogInterval()
+			if ok != tt.wantOK {
+				t.Fatalf("WatchdogInterval() ok = %v, want %v", ok, tt.wantOK)
+			}
+
+			if ok && interval != tt.wantResult {
+				t.Errorf("WatchdogInterval() = %v, want %v", interval, tt.wantResult)
+			}
+		})
+	}
+}